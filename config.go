@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Config is the root of the YAML configuration file, covering input routing
+// and the status-reaction pipeline's consumers.
+type Config struct {
+	Input     InputConfig      `mapstructure:"input"`
+	Moodbar   MoodbarConfig    `mapstructure:"moodbar"`
+	Scrobble  ScrobbleConfig   `mapstructure:"scrobble"`
+	ExecHooks []ExecHookConfig `mapstructure:"exec_hooks"`
+	Triggers  TriggersConfig   `mapstructure:"triggers"`
+}
+
+// InputConfig lists every evdev input device the controller should listen
+// on, replacing the single --input flag.
+type InputConfig struct {
+	Devices []InputDevice `mapstructure:"devices"`
+}
+
+// InputDevice describes one evdev device: where to open it, whether to grab
+// it exclusively (EVIOCGRAB), and how its keys map to dispatcher actions.
+type InputDevice struct {
+	Path   string                `mapstructure:"path"`
+	Grab   bool                  `mapstructure:"grab"`
+	Keymap map[string]KeyBinding `mapstructure:"keymap"`
+}
+
+// KeyBinding maps an evdev key name (e.g. "KEY_NEXTSONG") to a
+// ControlEvent-shaped action, with an optional value for things like seek
+// seconds or volume deltas.
+type KeyBinding struct {
+	Action string `mapstructure:"action"`
+	Value  int    `mapstructure:"value"`
+	Arg    string `mapstructure:"arg"`
+}
+
+// MoodbarConfig configures the "moodbar" pipeline consumer, which drives an
+// LED strip (or any shell command) from the current song's moodbar.
+type MoodbarConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MusicDir joins with the relative "file" MPD reports to get a path the
+	// moodbar binary can read.
+	MusicDir string `mapstructure:"music_dir"`
+	// SPIDevice, if set, writes each frame's RGB bytes straight to this
+	// spidev node (e.g. /dev/spidev0.0) for a WS281x strip.
+	SPIDevice string `mapstructure:"spi_device"`
+	// Command, if set instead, is run once per frame with R G B appended as
+	// arguments.
+	Command string `mapstructure:"command"`
+}
+
+// ScrobbleConfig configures the "scrobble" pipeline consumer.
+type ScrobbleConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Backend string `mapstructure:"backend"` // "lastfm" or "listenbrainz"
+
+	// Last.fm track.updateNowPlaying/track.scrobble credentials.
+	LastFMAPIKey     string `mapstructure:"lastfm_api_key"`
+	LastFMAPISecret  string `mapstructure:"lastfm_api_secret"`
+	LastFMSessionKey string `mapstructure:"lastfm_session_key"`
+
+	// ListenBrainz user token (Settings -> API token).
+	ListenBrainzToken string `mapstructure:"listenbrainz_token"`
+}
+
+// ExecHookConfig runs Command whenever the player transitions to the On
+// state ("play", "pause", "stop"), passing Args as its argv with
+// {{.Title}}/{{.Artist}}/{{.Album}} template substitution applied to each
+// argument independently. Command is run directly (no shell), so song
+// metadata can never be interpreted as shell syntax.
+type ExecHookConfig struct {
+	On      string   `mapstructure:"on"`
+	Command string   `mapstructure:"command"`
+	Args    []string `mapstructure:"args"`
+}
+
+// TriggersConfig generalizes the single home/media/control subscription
+// into an inbound MQTT/HTTP -> action bridge and an outbound MPD-event ->
+// MQTT/HTTP bridge, so the controller can glue MPD to anything, not just a
+// dedicated MQTT broker.
+type TriggersConfig struct {
+	Inbound  []InboundTriggerRule  `mapstructure:"inbound"`
+	Outbound []OutboundTriggerRule `mapstructure:"outbound"`
+	HTTP     HTTPTriggerConfig     `mapstructure:"http"`
+}
+
+// InboundTriggerRule subscribes to an MQTT topic filter (paho-style, with +
+// and # wildcards) and runs Action when a message arrives. Action is either
+// a dispatcher action name (play, pause, seek, ...) or one of the special
+// "exec"/"http" actions, both configured via Args. For "exec", Args["command"]
+// is the program to run and ExecArgs are its arguments, each templated
+// independently and passed straight to exec.Command (no shell), so the MQTT
+// topic/payload can never be interpreted as shell syntax.
+type InboundTriggerRule struct {
+	Match    string            `mapstructure:"match"`
+	Action   string            `mapstructure:"action"`
+	Args     map[string]string `mapstructure:"args"`
+	ExecArgs []string          `mapstructure:"exec_args"`
+}
+
+// OutboundTriggerRule fires when an MPDEvent matches On - either a bare
+// subsystem name ("player", "playlist", "mixer") or a "key==value" status
+// condition (e.g. "state==play") - and emits Body, rendered as a template,
+// to an MQTT topic or an HTTP endpoint.
+type OutboundTriggerRule struct {
+	On      string            `mapstructure:"on"`
+	Emit    string            `mapstructure:"emit"` // "mqtt" or "http"
+	Target  string            `mapstructure:"target"`
+	Method  string            `mapstructure:"method"`
+	Headers map[string]string `mapstructure:"headers"`
+	Body    string            `mapstructure:"body"`
+}
+
+// HTTPTriggerConfig enables the inbound /trigger/<action> HTTP server, for
+// bridging devices that only speak HTTP (e.g. a Shelly button) into the
+// dispatcher. Addr defaults to a loopback-only address, and every request
+// must present Token (a shared secret) via the X-Auth-Token header - this
+// endpoint can reach actions like poweroff with no other authentication.
+type HTTPTriggerConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Addr    string `mapstructure:"addr"`
+	Token   string `mapstructure:"token"`
+}
+
+// loadConfig reads and unmarshals the YAML config file at path.
+func loadConfig(path string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"text/template"
+)
+
+// songFields is the template context available to exec hook commands as
+// {{.Title}}, {{.Artist}}, {{.Album}}.
+type songFields struct {
+	Title  string
+	Artist string
+	Album  string
+}
+
+// execHookConsumer runs Command directly (no shell) whenever the player
+// transitions into the configured state, with each argument independently
+// rendered from a user-configured template.
+type execHookConsumer struct {
+	on      string
+	command string
+	argTmpl []*template.Template
+
+	mu        sync.Mutex
+	lastState string
+}
+
+func newExecHookConsumer(cfg ExecHookConfig) (*execHookConsumer, error) {
+	argTmpl := make([]*template.Template, len(cfg.Args))
+	for i, a := range cfg.Args {
+		tmpl, err := template.New("exec_hook_arg").Parse(a)
+		if err != nil {
+			return nil, err
+		}
+		argTmpl[i] = tmpl
+	}
+	return &execHookConsumer{on: cfg.On, command: cfg.Command, argTmpl: argTmpl}, nil
+}
+
+func (h *execHookConsumer) handle(ev MPDEvent) {
+	if ev.Subsystem != "player" {
+		return
+	}
+	state := ev.Status["state"]
+
+	h.mu.Lock()
+	transitioned := state == h.on && h.lastState != h.on
+	h.lastState = state
+	h.mu.Unlock()
+
+	if !transitioned {
+		return
+	}
+
+	fields := songFields{Title: ev.Song["Title"], Artist: ev.Song["Artist"], Album: ev.Song["Album"]}
+	args := make([]string, len(h.argTmpl))
+	for i, tmpl := range h.argTmpl {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, fields); err != nil {
+			logger.Error("Exec hook template error", slog.Any("err", err))
+			return
+		}
+		args[i] = buf.String()
+	}
+
+	go func() {
+		// Run the configured program directly with its rendered arguments, so
+		// song metadata (e.g. a crafted Title tag) is passed as a single argv
+		// entry and can never be interpreted as shell syntax.
+		if err := exec.Command(h.command, args...).Run(); err != nil {
+			logger.Error("Exec hook command failed", slog.Any("command", h.command), slog.Any("args", args), slog.Any("err", err))
+		}
+	}()
+}
+
+// registerExecHookConsumers wires up one consumer per configured exec hook.
+func registerExecHookConsumers(cfgs []ExecHookConfig) {
+	for i, cfg := range cfgs {
+		h, err := newExecHookConsumer(cfg)
+		if err != nil {
+			logger.Error("Invalid exec hook template", slog.Any("on", cfg.On), slog.Any("err", err))
+			continue
+		}
+		RegisterConsumer(fmt.Sprintf("exec-hook-%d", i), h.handle)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	evdev "github.com/holoplot/go-evdev"
+)
+
+// evdevKeyByName resolves the key names used in the YAML keymap (e.g.
+// "KEY_NEXTSONG", "KEY_F1") to their evdev codes. Only the keys a media
+// remote, FLIRC dongle, numpad or GPIO button board would plausibly send are
+// listed; extend as new devices need new keys.
+var evdevKeyByName = map[string]evdev.EvCode{
+	"KEY_PLAY":         evdev.KEY_PLAY,
+	"KEY_PAUSE":        evdev.KEY_PAUSE,
+	"KEY_PLAYPAUSE":    evdev.KEY_PLAYPAUSE,
+	"KEY_STOP":         evdev.KEY_STOP,
+	"KEY_STOPCD":       evdev.KEY_STOPCD,
+	"KEY_NEXTSONG":     evdev.KEY_NEXTSONG,
+	"KEY_PREVIOUSSONG": evdev.KEY_PREVIOUSSONG,
+	"KEY_FORWARD":      evdev.KEY_FORWARD,
+	"KEY_FASTFORWARD":  evdev.KEY_FASTFORWARD,
+	"KEY_REWIND":       evdev.KEY_REWIND,
+	"KEY_POWER":        evdev.KEY_POWER,
+	"KEY_VOLUMEUP":     evdev.KEY_VOLUMEUP,
+	"KEY_VOLUMEDOWN":   evdev.KEY_VOLUMEDOWN,
+	"KEY_MUTE":         evdev.KEY_MUTE,
+	"KEY_F1":           evdev.KEY_F1,
+	"KEY_F2":           evdev.KEY_F2,
+	"KEY_F3":           evdev.KEY_F3,
+	"KEY_F4":           evdev.KEY_F4,
+	"KEY_F5":           evdev.KEY_F5,
+	"KEY_F6":           evdev.KEY_F6,
+	"KEY_F7":           evdev.KEY_F7,
+	"KEY_F8":           evdev.KEY_F8,
+	"KEY_F9":           evdev.KEY_F9,
+	"KEY_F10":          evdev.KEY_F10,
+	"KEY_F11":          evdev.KEY_F11,
+	"KEY_F12":          evdev.KEY_F12,
+	"KEY_0":            evdev.KEY_0,
+	"KEY_1":            evdev.KEY_1,
+	"KEY_2":            evdev.KEY_2,
+	"KEY_3":            evdev.KEY_3,
+	"KEY_4":            evdev.KEY_4,
+	"KEY_5":            evdev.KEY_5,
+	"KEY_6":            evdev.KEY_6,
+	"KEY_7":            evdev.KEY_7,
+	"KEY_8":            evdev.KEY_8,
+	"KEY_9":            evdev.KEY_9,
+}
+
+// startInputDevices spins up one reader goroutine per configured input
+// device, each pushing ControlEvents into the shared events channel per its
+// own keymap.
+func startInputDevices(devices []InputDevice, events chan<- ControlEvent) {
+	for _, dev := range devices {
+		go runInputDevice(dev, events)
+	}
+}
+
+// runInputDevice owns one device for the life of the process: it opens the
+// device, grabs it if requested, reads until an error, then closes and
+// retries with backoff instead of spinning in a tight loop. This keeps
+// devices that are unplugged and replugged (FLIRC dongles, USB numpads)
+// working without a restart.
+func runInputDevice(cfg InputDevice, events chan<- ControlEvent) {
+	keymap := resolveKeymap(cfg.Keymap)
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+
+	for {
+		dev, err := evdev.Open(cfg.Path)
+		if err != nil {
+			logger.Error("Failed to open input device, retrying", slog.Any("device", cfg.Path), slog.Any("err", err), slog.Any("backoff", backoff))
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		logger.Info("Listening on input device", slog.Any("device", cfg.Path))
+
+		if cfg.Grab {
+			if err := dev.Grab(); err != nil {
+				logger.Warn("Failed to grab input device", slog.Any("device", cfg.Path), slog.Any("err", err))
+			}
+		}
+
+		readErr := readInputDevice(dev, keymap, events)
+		dev.Close()
+		logger.Warn("Input device read error, reopening", slog.Any("device", cfg.Path), slog.Any("err", readErr))
+		time.Sleep(backoff)
+	}
+}
+
+// resolveKeymap converts a YAML keymap (key name -> binding) into a
+// code-indexed map for fast dispatch on every read event.
+func resolveKeymap(keymap map[string]KeyBinding) map[evdev.EvCode]KeyBinding {
+	resolved := make(map[evdev.EvCode]KeyBinding, len(keymap))
+	for name, binding := range keymap {
+		code, ok := evdevKeyByName[name]
+		if !ok {
+			logger.Warn("Unknown evdev key name in keymap", slog.Any("key", name))
+			continue
+		}
+		resolved[code] = binding
+	}
+	return resolved
+}
+
+// readInputDevice blocks reading key-down events off dev and dispatching
+// their bound actions until a read error occurs.
+func readInputDevice(dev *evdev.InputDevice, keymap map[evdev.EvCode]KeyBinding, events chan<- ControlEvent) error {
+	for {
+		inputEvents, err := dev.ReadSlice(64)
+		if err != nil {
+			return err
+		}
+		for _, e := range inputEvents {
+			if e.Type != evdev.EV_KEY || e.Value != 1 {
+				continue
+			}
+			binding, ok := keymap[e.Code]
+			if !ok {
+				continue
+			}
+			events <- ControlEvent{Source: "input", Action: binding.Action, Value: binding.Value, Arg: binding.Arg}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+// TimedColor is one moodbar sample mapped to a point in the current song's
+// timeline.
+type TimedColor struct {
+	Elapsed time.Duration
+	R, G, B byte
+}
+
+// MoodbarSink receives one TimedColor per frame and is responsible for
+// getting it onto the hardware or wherever else the user wants it.
+type MoodbarSink interface {
+	Push(TimedColor) error
+}
+
+// ShellSink runs Command once per frame with the RGB bytes appended as
+// decimal arguments.
+type ShellSink struct {
+	Command string
+}
+
+func (s ShellSink) Push(c TimedColor) error {
+	return exec.Command(s.Command, strconv.Itoa(int(c.R)), strconv.Itoa(int(c.G)), strconv.Itoa(int(c.B))).Run()
+}
+
+// SPISink writes a frame's raw RGB bytes straight to a spidev character
+// device, for driving a WS281x strip wired to the SPI bus.
+type SPISink struct {
+	Device string
+}
+
+func (s SPISink) Push(c TimedColor) error {
+	f, err := os.OpenFile(s.Device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write([]byte{c.R, c.G, c.B})
+	return err
+}
+
+// moodbarConsumer renders and caches the moodbar for the currently playing
+// file and pushes the sample matching the reported elapsed time to sink.
+type moodbarConsumer struct {
+	sink     MoodbarSink
+	musicDir string
+
+	mu         sync.Mutex
+	file       string
+	data       []byte
+	generating bool
+}
+
+func newMoodbarConsumer(sink MoodbarSink, musicDir string) *moodbarConsumer {
+	return &moodbarConsumer{sink: sink, musicDir: musicDir}
+}
+
+func (m *moodbarConsumer) handle(ev MPDEvent) {
+	if ev.Subsystem != "player" {
+		return
+	}
+	file := ev.Song["file"]
+	if file == "" {
+		return
+	}
+
+	// Generating the moodbar shells out to an external binary, which can be
+	// slow; run it off the pipeline's dispatch goroutine so it can't stall
+	// the other consumers for this event.
+	go m.render(file, ev.Status)
+}
+
+func (m *moodbarConsumer) render(file string, status mpd.Attrs) {
+	m.mu.Lock()
+	if file != m.file && m.generating {
+		// Another goroutine is already rendering a moodbar (for this file or
+		// a different one); skip this event rather than shelling out twice.
+		m.mu.Unlock()
+		return
+	}
+	if file != m.file {
+		m.generating = true
+		m.mu.Unlock()
+
+		data, err := runMoodbar(filepath.Join(m.musicDir, file))
+
+		m.mu.Lock()
+		m.generating = false
+		if err != nil {
+			m.mu.Unlock()
+			logger.Error("Moodbar generation failed", slog.Any("file", file), slog.Any("err", err))
+			return
+		}
+		m.file = file
+		m.data = data
+	}
+	data, current := m.data, m.file
+	m.mu.Unlock()
+
+	if current != file {
+		return
+	}
+
+	numFrames := len(data) / 3
+	if numFrames == 0 {
+		return
+	}
+
+	elapsedSec, _ := strconv.ParseFloat(status["elapsed"], 64)
+	durationSec, _ := strconv.ParseFloat(status["duration"], 64)
+	if durationSec <= 0 {
+		return
+	}
+
+	idx := int(elapsedSec / durationSec * float64(numFrames))
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= numFrames {
+		idx = numFrames - 1
+	}
+
+	frame := data[idx*3 : idx*3+3]
+	sample := TimedColor{
+		Elapsed: time.Duration(elapsedSec * float64(time.Second)),
+		R:       frame[0],
+		G:       frame[1],
+		B:       frame[2],
+	}
+	if err := m.sink.Push(sample); err != nil {
+		logger.Error("Moodbar sink push failed", slog.Any("err", err))
+	}
+}
+
+// runMoodbar shells out to the moodbar binary and returns its raw
+// 3-byte-per-frame RGB stream.
+func runMoodbar(path string) ([]byte, error) {
+	return exec.Command("moodbar", "-o", "-", path).Output()
+}
+
+// registerMoodbarConsumer wires up the moodbar consumer if enabled and a
+// sink is configured.
+func registerMoodbarConsumer(cfg MoodbarConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	var sink MoodbarSink
+	switch {
+	case cfg.SPIDevice != "":
+		sink = SPISink{Device: cfg.SPIDevice}
+	case cfg.Command != "":
+		sink = ShellSink{Command: cfg.Command}
+	default:
+		logger.Warn("Moodbar consumer enabled but no spi_device or command configured")
+		return
+	}
+
+	m := newMoodbarConsumer(sink, cfg.MusicDir)
+	RegisterConsumer("moodbar", m.handle)
+}
@@ -1,26 +1,87 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/fhs/gompd/v2/mpd"
+)
 
-	evdev "github.com/holoplot/go-evdev"
+// MQTT topics used for both plain remote control and Home Assistant integration.
+const (
+	nodeID              = "mpd-controller"
+	controlTopic        = "home/media/control"
+	statusTopic         = "home/media/status"
+	haStateTopic        = "home/media/ha_state"
+	availabilityTopic   = "home/media/availability"
+	volumeSetTopic      = "home/media/volume_set"
+	mediaSeekTopic      = "home/media/media_seek"
+	mediaPositionTopic  = "home/media/media_position"
+	haDiscoveryTopicFmt = "homeassistant/media_player/%s/config"
+
+	payloadOnline  = "online"
+	payloadOffline = "offline"
 )
 
+// Supported media_player features, matching Home Assistant's
+// MediaPlayerEntityFeature bitmask: PAUSE|SEEK|VOLUME_SET|PREVIOUS_TRACK|
+// NEXT_TRACK|STOP|PLAY.
+const haSupportedFeatures = 1 | 2 | 4 | 16 | 32 | 4096 | 16384
+
+// mqttOnline tracks whether the MQTT connection is currently up, flipped by
+// the connect and connection-lost handlers in startMQTT.
+var mqttOnline atomic.Bool
+
+// mqttTLSConfig holds the paths needed to dial a TLS-secured broker
+// (ssl:// / tls:// URIs).
+type mqttTLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+}
+
 // Define event types for our dispatcher
 type ControlEvent struct {
 	Source string // "mqtt" or "input"
 	Action string // play, pause, next, prev, stop, seek+
-	Value  int    // for seek seconds, etc.
+	Value  int    // for seek seconds, volume percent/delta, etc.
+	Arg    string // for actions that take a name, e.g. playlist_load
+}
+
+// haDiscoveryConfig is the retained payload published to Home Assistant's
+// MQTT discovery topic so the controller shows up as a media_player entity
+// without any manual YAML.
+type haDiscoveryConfig struct {
+	Name                string `json:"name"`
+	UniqueID            string `json:"unique_id"`
+	CommandTopic        string `json:"command_topic"`
+	StateTopic          string `json:"state_topic"`
+	JSONAttributesTopic string `json:"json_attributes_topic"`
+	AvailabilityTopic   string `json:"availability_topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+	VolumeSetTopic      string `json:"volume_set_topic"`
+	MediaSeekTopic      string `json:"media_seek_topic"`
+	SupportedFeatures   int    `json:"supported_features"`
+	Device              struct {
+		Identifiers  []string `json:"identifiers"`
+		Name         string   `json:"name"`
+		Model        string   `json:"model"`
+		Manufacturer string   `json:"manufacturer"`
+	} `json:"device"`
 }
 
 type SafeMPDClient struct {
@@ -37,78 +98,171 @@ func (s *SafeMPDClient) Get() *mpd.Client {
 }
 
 // ---- MQTT listener ----
-func startMQTT(events chan<- ControlEvent, server, user, pass string) mqtt.Client {
+func startMQTT(events chan<- ControlEvent, server, user, pass string, tlsCfg mqttTLSConfig, inboundTriggers []InboundTriggerRule) mqtt.Client {
 	opts := mqtt.NewClientOptions().AddBroker(server)
-	opts.SetClientID("mpd-controller")
+	opts.SetClientID(nodeID)
 	if user != "" {
 		opts.SetUsername(user)
 	}
 	if pass != "" {
 		opts.SetPassword(pass)
 	}
-	client := mqtt.NewClient(opts)
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		logger.Error("MQTT connect error", slog.Any("err", token.Error()))
+	tlsConfig, err := buildTLSConfig(tlsCfg)
+	if err != nil {
+		logger.Error("Failed to build MQTT TLS config", slog.Any("err", err))
 		os.Exit(1)
 	}
-	logger.Info("Connected to MQTT", slog.Any("server", server))
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 
-	// Subscribe for remote control
-	client.Subscribe("home/media/control", 0, func(_ mqtt.Client, msg mqtt.Message) {
-		payload := string(msg.Payload())
-		events <- ControlEvent{Source: "mqtt", Action: payload}
+	// Last-Will-and-Testament: if the process dies without disconnecting
+	// cleanly, the broker flips the availability topic for us.
+	opts.SetWill(availabilityTopic, payloadOffline, 0, true)
+
+	// Keep reconnecting through flaky networks instead of giving up.
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(2 * time.Second)
+	opts.SetKeepAlive(30 * time.Second)
+	opts.SetMaxReconnectInterval(1 * time.Minute)
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		logger.Info("Connected to MQTT", slog.Any("server", server))
+		mqttOnline.Store(true)
+		// Re-subscribe and republish retained state on every (re)connect,
+		// since the broker does not remember our subscriptions across drops
+		// (CleanSession is left at its default of true).
+		subscribeControlTopics(client, events)
+		subscribeInboundTriggers(client, events, inboundTriggers)
+		publishAvailability(client, true)
+		publishDiscoveryConfig(client)
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		logger.Warn("MQTT connection lost", slog.Any("err", err))
+		mqttOnline.Store(false)
 	})
 
+	client := mqtt.NewClient(opts)
+
+	// With ConnectRetry set, Connect() retries forever in the background and
+	// its token only completes once a connection succeeds, so waiting on it
+	// here would hang the rest of main() if the broker is unreachable at
+	// boot. Log the outcome asynchronously instead and let the MPD/input
+	// side of the controller come up regardless.
+	token := client.Connect()
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			logger.Error("MQTT connect error", slog.Any("err", token.Error()))
+		}
+	}()
+
 	return client
 }
 
-// ---- Input listener ----
-func startInput(devPath string, events chan<- ControlEvent) {
-	dev, err := evdev.Open(devPath)
-	if err != nil {
-		logger.Error("Failed to open input device", slog.Any("err", err))
-		os.Exit(1)
+// buildTLSConfig loads a *tls.Config for TLS-secured brokers (ssl://, tls://)
+// from the given CA/cert/key file paths. Returns (nil, nil) when no TLS
+// material was configured, so the caller can fall back to a plain dial.
+func buildTLSConfig(cfg mqttTLSConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" && !cfg.Insecure {
+		return nil, nil
 	}
-	logger.Info("Listening on input device", slog.Any("device", devPath))
 
-	go func() {
-		for {
-			inputEvents, err := dev.ReadSlice(64)
-			if err != nil {
-				logger.Error("Error reading input", slog.Any("err", err))
-				continue
-			}
-			for _, e := range inputEvents {
-				if e.Type == evdev.EV_KEY && e.Value == 1 {
-					switch e.Code {
-					case evdev.KEY_PLAY:
-						events <- ControlEvent{Source: "input", Action: "play"}
-					case evdev.KEY_PAUSE:
-						events <- ControlEvent{Source: "input", Action: "pause"}
-					case evdev.KEY_PLAYPAUSE:
-						events <- ControlEvent{Source: "input", Action: "toggle"}
-					case evdev.KEY_STOPCD:
-						events <- ControlEvent{Source: "input", Action: "stop"}
-					case evdev.KEY_STOP:
-						events <- ControlEvent{Source: "input", Action: "stop"}
-					case evdev.KEY_NEXTSONG:
-						events <- ControlEvent{Source: "input", Action: "next"}
-					case evdev.KEY_PREVIOUSSONG:
-						events <- ControlEvent{Source: "input", Action: "prev"}
-					case evdev.KEY_FORWARD:
-						events <- ControlEvent{Source: "input", Action: "seek", Value: 10}
-					case evdev.KEY_FASTFORWARD:
-						events <- ControlEvent{Source: "input", Action: "seek", Value: 10}
-					case evdev.KEY_REWIND:
-						events <- ControlEvent{Source: "input", Action: "seek", Value: -10}
-					case evdev.KEY_POWER:
-						events <- ControlEvent{Action: "poweroff"}
-					}
-				}
-			}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading MQTT CA file: %w", err)
 		}
-	}()
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates parsed from MQTT CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading MQTT client keypair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// subscribeControlTopics wires up the plain remote-control topic plus the
+// Home Assistant media_player command subtopics onto the same events channel.
+func subscribeControlTopics(client mqtt.Client, events chan<- ControlEvent) {
+	client.Subscribe(controlTopic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		payload := string(msg.Payload())
+		events <- ControlEvent{Source: "mqtt", Action: payload}
+	})
+
+	client.Subscribe(volumeSetTopic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		volume, err := strconv.Atoi(string(msg.Payload()))
+		if err != nil {
+			logger.Warn("Invalid volume_set payload", slog.Any("payload", string(msg.Payload())))
+			return
+		}
+		events <- ControlEvent{Source: "mqtt", Action: "volume_set", Value: volume}
+	})
+
+	seekHandler := func(_ mqtt.Client, msg mqtt.Message) {
+		position, err := strconv.Atoi(string(msg.Payload()))
+		if err != nil {
+			logger.Warn("Invalid seek payload", slog.Any("payload", string(msg.Payload())))
+			return
+		}
+		events <- ControlEvent{Source: "mqtt", Action: "seek_abs", Value: position}
+	}
+	client.Subscribe(mediaSeekTopic, 0, seekHandler)
+	client.Subscribe(mediaPositionTopic, 0, seekHandler)
+}
+
+// publishAvailability publishes a retained online/offline payload to the
+// availability topic referenced by the Home Assistant discovery config.
+func publishAvailability(client mqtt.Client, online bool) {
+	payload := payloadOffline
+	if online {
+		payload = payloadOnline
+	}
+	client.Publish(availabilityTopic, 0, true, payload)
+}
+
+// publishDiscoveryConfig publishes the retained Home Assistant MQTT discovery
+// document describing this controller as a media_player entity, so it shows
+// up with full control (play/pause/stop/next/prev/seek/volume) without any
+// manual YAML on the HA side.
+func publishDiscoveryConfig(client mqtt.Client) {
+	cfg := haDiscoveryConfig{
+		Name:                "MPD Controller",
+		UniqueID:            nodeID,
+		CommandTopic:        controlTopic,
+		StateTopic:          haStateTopic,
+		JSONAttributesTopic: statusTopic,
+		AvailabilityTopic:   availabilityTopic,
+		PayloadAvailable:    payloadOnline,
+		PayloadNotAvailable: payloadOffline,
+		VolumeSetTopic:      volumeSetTopic,
+		MediaSeekTopic:      mediaSeekTopic,
+		SupportedFeatures:   haSupportedFeatures,
+	}
+	cfg.Device.Identifiers = []string{nodeID}
+	cfg.Device.Name = "MPD Controller"
+	cfg.Device.Model = "mpd-controller"
+	cfg.Device.Manufacturer = "gompd"
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		logger.Error("HA discovery JSON encode error", slog.Any("err", err))
+		return
+	}
+	topic := fmt.Sprintf(haDiscoveryTopicFmt, nodeID)
+	client.Publish(topic, 0, true, data)
 }
 
 // ---- Dispatcher ----
@@ -137,6 +291,24 @@ func dispatcher(safeClient *SafeMPDClient, events <-chan ControlEvent) {
 			mpdClient.Previous()
 		case "seek":
 			mpdClient.SeekCur(time.Duration(ev.Value)*time.Second, true)
+		case "seek_abs":
+			mpdClient.SeekCur(time.Duration(ev.Value)*time.Second, false)
+		case "volume_set", "volume=":
+			if err := mpdClient.SetVolume(ev.Value); err != nil {
+				logger.Error("Failed to set volume", slog.Any("err", err))
+			}
+		case "volume+":
+			adjustVolume(mpdClient, ev.Value)
+		case "volume-":
+			adjustVolume(mpdClient, -ev.Value)
+		case "toggle_random":
+			toggleBoolOption(mpdClient, "random", mpdClient.Random)
+		case "toggle_repeat":
+			toggleBoolOption(mpdClient, "repeat", mpdClient.Repeat)
+		case "playlist_load":
+			if err := mpdClient.PlaylistLoad(ev.Arg, -1, -1); err != nil {
+				logger.Error("Failed to load playlist", slog.Any("playlist", ev.Arg), slog.Any("err", err))
+			}
 		case "poweroff":
 			logger.Warn("Powering off system...")
 			err := exec.Command("systemctl", "poweroff").Run()
@@ -149,7 +321,45 @@ func dispatcher(safeClient *SafeMPDClient, events <-chan ControlEvent) {
 	}
 }
 
-func startStatusPublisher(mpdAddr string, mpdClient **mpd.Client, mqttClient mqtt.Client, stopChan <-chan struct{}) {
+// adjustVolume reads the current MPD volume and nudges it by delta, clamped
+// to the valid 0-100 range.
+func adjustVolume(mpdClient *mpd.Client, delta int) {
+	status, err := mpdClient.Status()
+	if err != nil {
+		logger.Error("Failed to read status for volume adjust", slog.Any("err", err))
+		return
+	}
+	current, err := strconv.Atoi(status["volume"])
+	if err != nil {
+		current = 0
+	}
+	target := current + delta
+	if target < 0 {
+		target = 0
+	}
+	if target > 100 {
+		target = 100
+	}
+	if err := mpdClient.SetVolume(target); err != nil {
+		logger.Error("Failed to set volume", slog.Any("err", err))
+	}
+}
+
+// toggleBoolOption flips an MPD boolean playback option (random, repeat, ...)
+// by reading its current value out of Status and calling setter with the
+// opposite.
+func toggleBoolOption(mpdClient *mpd.Client, statusKey string, setter func(bool) error) {
+	status, err := mpdClient.Status()
+	if err != nil {
+		logger.Error("Failed to read status for toggle", slog.Any("option", statusKey), slog.Any("err", err))
+		return
+	}
+	if err := setter(status[statusKey] != "1"); err != nil {
+		logger.Error("Failed to toggle option", slog.Any("option", statusKey), slog.Any("err", err))
+	}
+}
+
+func startStatusPublisher(mpdAddr string, mpdClient **mpd.Client, stopChan <-chan struct{}) {
 	go func() {
 		ticker := time.NewTicker(45 * time.Second) // keepalive interval
 		defer ticker.Stop()
@@ -162,7 +372,7 @@ func startStatusPublisher(mpdAddr string, mpdClient **mpd.Client, mqttClient mqt
 			}
 
 			// Start or restart the watcher
-			w, err := mpd.NewWatcher("tcp", mpdAddr, "", "player playlist")
+			w, err := mpd.NewWatcher("tcp", mpdAddr, "", "player playlist mixer")
 			if err != nil {
 				logger.Error("Failed to start MPD watcher. Retrying in 2s...", slog.Any("err", err))
 				time.Sleep(2 * time.Second)
@@ -220,7 +430,7 @@ func startStatusPublisher(mpdAddr string, mpdClient **mpd.Client, mqttClient mqt
 						continue
 					}
 
-					sendMQTTStatus(mqttClient, "home/media/status", song, status)
+					dispatchMPDEvent(MPDEvent{Subsystem: subsystem, Status: status, Song: song})
 
 				case <-ticker.C:
 					// Periodic keepalive
@@ -256,14 +466,23 @@ func startStatusPublisher(mpdAddr string, mpdClient **mpd.Client, mqttClient mqt
 
 func sendMQTTStatus(mqttClient mqtt.Client, topic string, song mpd.Attrs, status mpd.Attrs) {
 
-	// Build JSON payload
+	// Build JSON payload, including the extra playback attributes Home
+	// Assistant's media_player needs to render position, volume and the
+	// repeat/random/single/consume toggles.
 	payload := map[string]string{
-		"state":  status["state"],
-		"time":   status["time"],
-		"title":  song["Title"],
-		"artist": song["Artist"],
-		"album":  song["Album"],
-		"file":   song["file"],
+		"state":    status["state"],
+		"time":     status["time"],
+		"elapsed":  status["elapsed"],
+		"duration": status["duration"],
+		"volume":   status["volume"],
+		"repeat":   status["repeat"],
+		"random":   status["random"],
+		"single":   status["single"],
+		"consume":  status["consume"],
+		"title":    song["Title"],
+		"artist":   song["Artist"],
+		"album":    song["Album"],
+		"file":     song["file"],
 	}
 
 	data, err := json.Marshal(payload)
@@ -274,20 +493,52 @@ func sendMQTTStatus(mqttClient mqtt.Client, topic string, song mpd.Attrs, status
 	mqttClient.Publish(topic, 0, true, data)
 }
 
+// mpdStateToHAState translates MPD's state vocabulary ("play", "pause",
+// "stop") into Home Assistant's media_player state_topic vocabulary, since
+// the two don't match and HA only recognizes its own enum there.
+func mpdStateToHAState(mpdState string) string {
+	switch mpdState {
+	case "play":
+		return "playing"
+	case "pause":
+		return "paused"
+	case "stop":
+		return "idle"
+	default:
+		return "idle"
+	}
+}
+
+// publishHAState publishes the Home Assistant state_topic value derived
+// from MPD's status, separately from the full status JSON published to
+// json_attributes_topic by sendMQTTStatus.
+func publishHAState(mqttClient mqtt.Client, status mpd.Attrs) {
+	mqttClient.Publish(haStateTopic, 0, true, mpdStateToHAState(status["state"]))
+}
+
 func main() {
 	// ---- Command-line flags ----
 	mpdServer := flag.String("mpd", "localhost:6600", "MPD server address (host:port)")
 	mqttServer := flag.String("mqtt-server", "tcp://localhost:1883", "MQTT server URI")
 	mqttUser := flag.String("mqtt-user", "", "MQTT username (optional)")
 	mqttPass := flag.String("mqtt-pass", "", "MQTT password (optional)")
-	inputDevice := flag.String("input", "/dev/input/eventX", "Input device path (FLIRC)")
+	mqttCA := flag.String("mqtt-ca", "", "Path to CA certificate PEM for a TLS broker (optional)")
+	mqttCert := flag.String("mqtt-cert", "", "Path to client certificate PEM for a TLS broker (optional)")
+	mqttKey := flag.String("mqtt-key", "", "Path to client key PEM for a TLS broker (optional)")
+	mqttInsecure := flag.Bool("mqtt-insecure", false, "Skip TLS certificate verification for the MQTT broker")
+	configPath := flag.String("config", "/etc/musicplayer/config.yaml", "Path to YAML config (input device routing, keymaps)")
 	flag.Parse()
 
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		logger.Error("Failed to load config", slog.Any("path", *configPath), slog.Any("err", err))
+		os.Exit(1)
+	}
+
 	events := make(chan ControlEvent, 10)
 
 	// Connect main MPD client on demand
 	var mpdClient *mpd.Client
-	var err error
 	mpdClient, err = mpd.Dial("tcp", *mpdServer)
 	if err != nil {
 		logger.Error("Initial MPD connect failed, will retry in watcher:", slog.Any("err", err))
@@ -299,14 +550,30 @@ func main() {
 	safeClient := &SafeMPDClient{client: &mpdClient}
 
 	// Start MQTT
-	mqttClient := startMQTT(events, *mqttServer, *mqttUser, *mqttPass)
+	tlsCfg := mqttTLSConfig{CAFile: *mqttCA, CertFile: *mqttCert, KeyFile: *mqttKey, Insecure: *mqttInsecure}
+	mqttClient := startMQTT(events, *mqttServer, *mqttUser, *mqttPass, tlsCfg, cfg.Triggers.Inbound)
+
+	// Start Input listener(s) ----
+	startInputDevices(cfg.Input.Devices, events)
+
+	// Wire up the side-effect pipeline: every MPD subsystem change is fanned
+	// out to these consumers, in addition to the retained MQTT status topic.
+	RegisterConsumer("mqtt-status", func(ev MPDEvent) {
+		sendMQTTStatus(mqttClient, statusTopic, ev.Song, ev.Status)
+		publishHAState(mqttClient, ev.Status)
+	})
+	registerMoodbarConsumer(cfg.Moodbar)
+	registerScrobbleConsumer(cfg.Scrobble)
+	registerExecHookConsumers(cfg.ExecHooks)
 
-	// Start Input listener ----
-	startInput(*inputDevice, events)
+	// Start the generalized trigger bridge's outbound (MPD event -> MQTT/HTTP)
+	// and inbound HTTP pieces; inbound MQTT rules are subscribed from
+	// startMQTT's OnConnectHandler above so they survive reconnects.
+	startTriggers(cfg.Triggers, mqttClient, events)
 
 	// Start MPD status publisher ----
 	stopChan := make(chan struct{})
-	startStatusPublisher(*mpdServer, &mpdClient, mqttClient, stopChan)
+	startStatusPublisher(*mpdServer, &mpdClient, stopChan)
 
 	// Handle Ctrl+C / SIGTERM
 	sigChan := make(chan os.Signal, 1)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+// MPDEvent is the snapshot handed to every registered consumer whenever the
+// watcher in startStatusPublisher reports a subsystem change.
+type MPDEvent struct {
+	Subsystem string
+	Status    mpd.Attrs
+	Song      mpd.Attrs
+}
+
+var (
+	consumersMu sync.Mutex
+	consumers   = map[string]func(MPDEvent){}
+)
+
+// RegisterConsumer adds a named consumer to the side-effect pipeline.
+// Consumers run synchronously, in no particular order, for every MPDEvent;
+// one that needs to do slow I/O (exec, HTTP, ...) should hand off to its own
+// goroutine rather than block the others. Registering under a name already
+// in use replaces the previous consumer.
+func RegisterConsumer(name string, fn func(MPDEvent)) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	consumers[name] = fn
+}
+
+// dispatchMPDEvent fans ev out to every registered consumer, isolating each
+// one so a panic in one doesn't take down the others. Consumers are called
+// one after another, so a slow one delays those that follow it.
+func dispatchMPDEvent(ev MPDEvent) {
+	consumersMu.Lock()
+	snapshot := make(map[string]func(MPDEvent), len(consumers))
+	for name, fn := range consumers {
+		snapshot[name] = fn
+	}
+	consumersMu.Unlock()
+
+	for name, fn := range snapshot {
+		runConsumer(name, fn, ev)
+	}
+}
+
+func runConsumer(name string, fn func(MPDEvent), ev MPDEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Consumer panicked", slog.Any("consumer", name), slog.Any("panic", r))
+		}
+	}()
+	fn(ev)
+}
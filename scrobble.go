@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+// Scrobbler submits now-playing and played-track events to a backend such as
+// Last.fm or ListenBrainz.
+type Scrobbler interface {
+	NowPlaying(song mpd.Attrs) error
+	Scrobble(song mpd.Attrs, startedAt time.Time) error
+}
+
+// scrobbleConsumer watches for the player transitioning into "play" and
+// fires NowPlaying immediately, then schedules Scrobble for whichever comes
+// first: half the track's duration, or 4 minutes in.
+type scrobbleConsumer struct {
+	backend Scrobbler
+
+	mu        sync.Mutex
+	lastState string
+	file      string
+	timer     *time.Timer
+}
+
+func newScrobbleConsumer(backend Scrobbler) *scrobbleConsumer {
+	return &scrobbleConsumer{backend: backend}
+}
+
+func (s *scrobbleConsumer) handle(ev MPDEvent) {
+	if ev.Subsystem != "player" {
+		return
+	}
+	state := ev.Status["state"]
+
+	s.mu.Lock()
+
+	songChanged := ev.Song["file"] != s.file
+	transitionedToPlay := state == "play" && (s.lastState != "play" || songChanged)
+	s.lastState = state
+
+	if !transitionedToPlay {
+		if state != "play" {
+			s.cancelPendingLocked()
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	s.cancelPendingLocked()
+	s.file = ev.Song["file"]
+
+	durationSec, _ := strconv.ParseFloat(ev.Status["duration"], 64)
+	threshold := 4 * time.Minute
+	if durationSec > 0 {
+		if half := time.Duration(durationSec / 2 * float64(time.Second)); half < threshold {
+			threshold = half
+		}
+	}
+
+	song, startedAt := ev.Song, time.Now()
+	s.timer = time.AfterFunc(threshold, func() {
+		if err := s.backend.Scrobble(song, startedAt); err != nil {
+			logger.Error("Scrobble submit failed", slog.Any("err", err))
+		}
+	})
+	s.mu.Unlock()
+
+	// NowPlaying is a synchronous HTTP call; run it off the pipeline's
+	// dispatch goroutine so a slow or unreachable scrobble backend can't
+	// stall the other consumers for this event.
+	go func() {
+		if err := s.backend.NowPlaying(song); err != nil {
+			logger.Error("Scrobbler now-playing failed", slog.Any("err", err))
+		}
+	}()
+}
+
+func (s *scrobbleConsumer) cancelPendingLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// registerScrobbleConsumer wires up the configured scrobble backend.
+func registerScrobbleConsumer(cfg ScrobbleConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	var backend Scrobbler
+	switch cfg.Backend {
+	case "lastfm":
+		backend = newLastFMScrobbler(cfg.LastFMAPIKey, cfg.LastFMAPISecret, cfg.LastFMSessionKey)
+	case "listenbrainz":
+		backend = newListenBrainzScrobbler(cfg.ListenBrainzToken)
+	default:
+		logger.Warn("Unknown scrobble backend", slog.Any("backend", cfg.Backend))
+		return
+	}
+
+	s := newScrobbleConsumer(backend)
+	RegisterConsumer("scrobble", s.handle)
+}
+
+// ---- Last.fm ----
+
+type lastFMScrobbler struct {
+	apiKey     string
+	apiSecret  string
+	sessionKey string
+	client     *http.Client
+}
+
+func newLastFMScrobbler(apiKey, apiSecret, sessionKey string) *lastFMScrobbler {
+	return &lastFMScrobbler{apiKey: apiKey, apiSecret: apiSecret, sessionKey: sessionKey, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (l *lastFMScrobbler) NowPlaying(song mpd.Attrs) error {
+	return l.call("track.updateNowPlaying", map[string]string{
+		"artist": song["Artist"],
+		"track":  song["Title"],
+		"album":  song["Album"],
+	})
+}
+
+func (l *lastFMScrobbler) Scrobble(song mpd.Attrs, startedAt time.Time) error {
+	return l.call("track.scrobble", map[string]string{
+		"artist":    song["Artist"],
+		"track":     song["Title"],
+		"album":     song["Album"],
+		"timestamp": strconv.FormatInt(startedAt.Unix(), 10),
+	})
+}
+
+func (l *lastFMScrobbler) call(method string, extra map[string]string) error {
+	params := map[string]string{
+		"method":  method,
+		"api_key": l.apiKey,
+		"sk":      l.sessionKey,
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	params["api_sig"] = lastFMSign(params, l.apiSecret)
+	params["format"] = "json"
+
+	form := url.Values{}
+	for k, v := range params {
+		form.Set(k, v)
+	}
+
+	resp, err := l.client.PostForm("https://ws.audioscrobbler.com/2.0/", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("last.fm %s failed: %s", method, resp.Status)
+	}
+	return nil
+}
+
+// lastFMSign implements Last.fm's API signature scheme: sort every param
+// except format/callback by key, concatenate key+value pairs, append the
+// shared secret, and MD5 the result.
+func lastFMSign(params map[string]string, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k == "format" || k == "callback" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString(params[k])
+	}
+	sb.WriteString(secret)
+
+	sum := md5.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ---- ListenBrainz ----
+
+type listenBrainzScrobbler struct {
+	token  string
+	client *http.Client
+}
+
+func newListenBrainzScrobbler(token string) *listenBrainzScrobbler {
+	return &listenBrainzScrobbler{token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (l *listenBrainzScrobbler) NowPlaying(song mpd.Attrs) error {
+	return l.submit("playing_now", song, 0)
+}
+
+func (l *listenBrainzScrobbler) Scrobble(song mpd.Attrs, startedAt time.Time) error {
+	return l.submit("single", song, startedAt.Unix())
+}
+
+func (l *listenBrainzScrobbler) submit(listenType string, song mpd.Attrs, listenedAt int64) error {
+	item := map[string]interface{}{
+		"track_metadata": map[string]interface{}{
+			"artist_name":  song["Artist"],
+			"track_name":   song["Title"],
+			"release_name": song["Album"],
+		},
+	}
+	if listenedAt > 0 {
+		item["listened_at"] = listenedAt
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"listen_type": listenType,
+		"payload":     []interface{}{item},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.listenbrainz.org/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+l.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("listenbrainz submit failed: %s", resp.Status)
+	}
+	return nil
+}
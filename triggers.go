@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// triggerContext is the template context available to inbound trigger
+// exec/http actions and outbound emit rules.
+type triggerContext struct {
+	Topic   string
+	Payload string
+	Title   string
+	Artist  string
+	Album   string
+	State   string
+}
+
+// startTriggers wires up the outbound MPD-event->external rules and the
+// inbound HTTP trigger server, turning the single home/media/control
+// subscription into a generic MPD glue layer. Inbound MQTT rules are
+// subscribed separately, from startMQTT's OnConnectHandler (see
+// subscribeInboundTriggers), so they're re-established on every reconnect.
+func startTriggers(cfg TriggersConfig, mqttClient mqtt.Client, events chan<- ControlEvent) {
+	if len(cfg.Outbound) > 0 {
+		RegisterConsumer("triggers-outbound", func(ev MPDEvent) {
+			dispatchOutboundTriggers(cfg.Outbound, mqttClient, ev)
+		})
+	}
+
+	if cfg.HTTP.Enabled {
+		startHTTPTriggerServer(cfg.HTTP, events)
+	}
+}
+
+// ---- inbound: MQTT -> action ----
+
+// subscribeInboundTriggers subscribes every configured inbound rule onto
+// client. Called from startMQTT's OnConnectHandler rather than once at
+// startup: paho's Subscribe() is a no-op against a client that isn't yet
+// IsConnected(), and the default CleanSession drops subscriptions on every
+// reconnect, so this has to run on every (re)connect to actually work.
+func subscribeInboundTriggers(client mqtt.Client, events chan<- ControlEvent, rules []InboundTriggerRule) {
+	for _, rule := range rules {
+		registerInboundTrigger(client, events, rule)
+	}
+}
+
+func registerInboundTrigger(client mqtt.Client, events chan<- ControlEvent, rule InboundTriggerRule) {
+	token := client.Subscribe(rule.Match, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		ctx := triggerContext{Topic: msg.Topic(), Payload: string(msg.Payload())}
+		runInboundAction(rule, ctx, events)
+	})
+	if token.Wait() && token.Error() != nil {
+		logger.Error("Inbound trigger subscribe failed", slog.Any("match", rule.Match), slog.Any("err", token.Error()))
+	}
+}
+
+func runInboundAction(rule InboundTriggerRule, ctx triggerContext, events chan<- ControlEvent) {
+	switch rule.Action {
+	case "exec":
+		runCommand(rule.Args["command"], rule.ExecArgs, ctx)
+	case "http":
+		if err := emitHTTP(rule.Args["url"], rule.Args["method"], nil, rule.Args["body"], ctx); err != nil {
+			logger.Error("Inbound trigger HTTP emit failed", slog.Any("err", err))
+		}
+	case "seek":
+		value, _ := strconv.Atoi(firstNonEmpty(rule.Args["seconds"], ctx.Payload))
+		events <- ControlEvent{Source: "trigger", Action: "seek_abs", Value: value}
+	default:
+		events <- ControlEvent{Source: "trigger", Action: rule.Action}
+	}
+}
+
+// ---- outbound: MPD event -> MQTT/HTTP ----
+
+func dispatchOutboundTriggers(rules []OutboundTriggerRule, mqttClient mqtt.Client, ev MPDEvent) {
+	ctx := triggerContext{
+		Title:  ev.Song["Title"],
+		Artist: ev.Song["Artist"],
+		Album:  ev.Song["Album"],
+		State:  ev.Status["state"],
+	}
+
+	for _, rule := range rules {
+		if !outboundRuleMatches(rule.On, ev) {
+			continue
+		}
+		switch rule.Emit {
+		case "mqtt":
+			body, err := renderTemplate(rule.Body, ctx)
+			if err != nil {
+				logger.Error("Outbound trigger MQTT template error", slog.Any("err", err))
+				continue
+			}
+			mqttClient.Publish(rule.Target, 0, false, body)
+		case "http":
+			if err := emitHTTP(rule.Target, rule.Method, rule.Headers, rule.Body, ctx); err != nil {
+				logger.Error("Outbound trigger HTTP emit failed", slog.Any("err", err))
+			}
+		default:
+			logger.Warn("Unknown outbound trigger emit type", slog.Any("emit", rule.Emit))
+		}
+	}
+}
+
+// outboundRuleMatches implements the two On syntaxes: a bare subsystem name
+// ("player", "playlist", "mixer") or a "key==value" status condition (e.g.
+// "state==play").
+func outboundRuleMatches(on string, ev MPDEvent) bool {
+	if key, value, ok := strings.Cut(on, "=="); ok {
+		return ev.Status[strings.TrimSpace(key)] == strings.TrimSpace(value)
+	}
+	return ev.Subsystem == on
+}
+
+// ---- inbound: HTTP -> action ----
+
+// startHTTPTriggerServer exposes /trigger/<action>?value=N&arg=X endpoints
+// that push ControlEvents into the same dispatcher channel as MQTT and
+// input devices, for bridging devices that only speak HTTP. Every request
+// must carry the configured Token via the X-Auth-Token header: these
+// actions include things like poweroff, and the server has no other access
+// control.
+func startHTTPTriggerServer(cfg HTTPTriggerConfig, events chan<- ControlEvent) {
+	addr := cfg.Addr
+	if addr == "" {
+		addr = "127.0.0.1:8080"
+	}
+	if cfg.Token == "" {
+		logger.Warn("HTTP trigger server enabled with no token configured; every request will be rejected until triggers.http.token is set")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trigger/", func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Token == "" || r.Header.Get("X-Auth-Token") != cfg.Token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		action := strings.TrimPrefix(r.URL.Path, "/trigger/")
+		if action == "" {
+			http.Error(w, "missing action", http.StatusBadRequest)
+			return
+		}
+
+		value := 0
+		if v := r.URL.Query().Get("value"); v != "" {
+			value, _ = strconv.Atoi(v)
+		}
+
+		events <- ControlEvent{Source: "http", Action: action, Value: value, Arg: r.URL.Query().Get("arg")}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	go func() {
+		logger.Info("HTTP trigger server listening", slog.Any("addr", addr))
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("HTTP trigger server stopped", slog.Any("err", err))
+		}
+	}()
+}
+
+// ---- shared helpers ----
+
+func renderTemplate(tmplStr string, ctx triggerContext) (string, error) {
+	tmpl, err := template.New("trigger").Parse(tmplStr)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// runCommand renders each of argTemplates independently against ctx and runs
+// program directly (no shell) with the results as its argv. The MQTT topic
+// and payload feeding ctx come from any publisher on the broker, so they
+// must never pass through a shell where they could be interpreted as
+// command syntax.
+func runCommand(program string, argTemplates []string, ctx triggerContext) {
+	if program == "" {
+		return
+	}
+	args := make([]string, len(argTemplates))
+	for i, a := range argTemplates {
+		rendered, err := renderTemplate(a, ctx)
+		if err != nil {
+			logger.Error("Trigger exec arg template error", slog.Any("err", err))
+			return
+		}
+		args[i] = rendered
+	}
+	go func() {
+		if err := exec.Command(program, args...).Run(); err != nil {
+			logger.Error("Trigger exec command failed", slog.Any("command", program), slog.Any("args", args), slog.Any("err", err))
+		}
+	}()
+}
+
+// emitHTTP POSTs (or sends via method) a rendered body template to target,
+// with any extra headers applied on top of a JSON content type.
+func emitHTTP(target, method string, headers map[string]string, bodyTemplate string, ctx triggerContext) error {
+	if target == "" {
+		return fmt.Errorf("http emit: no target configured")
+	}
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	body, err := renderTemplate(bodyTemplate, ctx)
+	if err != nil {
+		return fmt.Errorf("rendering http emit body: %w", err)
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("http emit to %s failed: %s", target, resp.Status)
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}